@@ -0,0 +1,170 @@
+// Package rules implements backfilling of Prometheus recording rules.
+//
+// Given one or more rule files and a time range, it evaluates every recording
+// rule against an existing Prometheus/Thanos HTTP API and writes the results
+// as TSDB blocks through blocks.MultiWriter, so a newly added recording rule
+// can be retroactively materialized without re-ingesting raw samples.
+package rules
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/oklog/ulid"
+	"github.com/pkg/errors"
+	"github.com/prometheus/client_golang/api"
+	promv1 "github.com/prometheus/client_golang/api/prometheus/v1"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/rulefmt"
+	"github.com/prometheus/prometheus/storage"
+	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
+
+	"github.com/yangtaoran/thanos-kit/importer/blocks"
+)
+
+// Config configures a rule backfill run.
+type Config struct {
+	// URL is the base URL of the Prometheus/Thanos query API to evaluate rules against.
+	URL string
+	// Start and End bound the historical range rules are evaluated over.
+	Start, End time.Time
+	// EvalInterval is the step used for a rule's range query, and the default used for
+	// groups that don't set their own `interval`.
+	EvalInterval time.Duration
+	// RuleFiles are parsed with rulefmt; alerting rules found in them are skipped.
+	RuleFiles []string
+}
+
+// Run evaluates every recording rule found in cfg.RuleFiles over cfg.Start..cfg.End
+// and appends the resulting series to w, flushing and returning the resulting block
+// ULIDs before returning. The range is chunked by sizeMillis so that range queries,
+// and the blocks w produces, line up with w's own block size.
+func Run(ctx context.Context, logger log.Logger, cfg Config, sizeMillis int64, w *blocks.MultiWriter) (ids []ulid.ULID, err error) {
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+	if sizeMillis <= 0 {
+		return nil, errors.Errorf("sizeMillis must be positive, got %d", sizeMillis)
+	}
+
+	groups, err := parseRuleFiles(cfg.RuleFiles)
+	if err != nil {
+		return nil, err
+	}
+
+	client, err := api.NewClient(api.Config{Address: cfg.URL})
+	if err != nil {
+		return nil, errors.Wrap(err, "new api client")
+	}
+	queryAPI := promv1.NewAPI(client)
+
+	app := w.Appender(ctx)
+	for _, g := range groups {
+		interval := cfg.EvalInterval
+		if g.Interval > 0 {
+			interval = time.Duration(g.Interval)
+		}
+		for _, r := range g.Rules {
+			if r.Record.Value == "" {
+				// Alerting rule; nothing to backfill.
+				continue
+			}
+			level.Info(logger).Log("msg", "backfilling recording rule", "group", g.Name, "record", r.Record.Value, "expr", r.Expr.Value)
+
+			for start := cfg.Start; start.Before(cfg.End); start = nextStart(start, sizeMillis, cfg.End) {
+				end := nextStart(start, sizeMillis, cfg.End)
+				if err := evalRange(ctx, queryAPI, app, r.Record.Value, r.Labels, r.Expr.Value, start, end, interval); err != nil {
+					return nil, errors.Wrapf(err, "evaluate rule %q over [%s, %s]", r.Record.Value, start, end)
+				}
+			}
+		}
+	}
+	if err := app.Commit(); err != nil {
+		return nil, errors.Wrap(err, "commit")
+	}
+
+	ids, err = w.Flush(ctx)
+	if err != nil {
+		return nil, errors.Wrap(err, "flush")
+	}
+	return ids, nil
+}
+
+// nextStart advances start by one sizeMillis-sized step, clamped to end. sizeMillis
+// must be positive; Run guards against a non-positive value so this never loops
+// forever returning start unchanged.
+func nextStart(start time.Time, sizeMillis int64, end time.Time) time.Time {
+	next := start.Add(time.Duration(sizeMillis) * time.Millisecond)
+	if next.After(end) {
+		return end
+	}
+	return next
+}
+
+// parseRuleFiles parses all rule files with rulefmt, collecting parse errors across
+// all of them before failing so a typo in one file doesn't hide problems in another.
+func parseRuleFiles(files []string) ([]rulefmt.RuleGroup, error) {
+	merr := tsdb_errors.NewMulti()
+	var groups []rulefmt.RuleGroup
+	for _, f := range files {
+		rgs, errs := rulefmt.ParseFile(f)
+		for _, e := range errs {
+			merr.Add(errors.Wrapf(e, "parse %s", f))
+		}
+		if rgs != nil {
+			groups = append(groups, rgs.Groups...)
+		}
+	}
+	if err := merr.Err(); err != nil {
+		return nil, err
+	}
+	return groups, nil
+}
+
+// evalRange issues a single range query for expr and appends every returned series as
+// (labels, ts, value) samples through app, stamping each with the rule's own identity
+// (record name and static labels) the same way Prometheus' own recording rule
+// evaluation does, rather than the raw labels the query happens to return.
+func evalRange(ctx context.Context, queryAPI promv1.API, app storage.Appender, recordName string, staticLabels map[string]string, expr string, start, end time.Time, step time.Duration) error {
+	val, warnings, err := queryAPI.QueryRange(ctx, expr, promv1.Range{Start: start, End: end, Step: step})
+	if err != nil {
+		return errors.Wrap(err, "range query")
+	}
+	for _, w := range warnings {
+		_ = w // Query warnings are discarded; only the returned matrix is used.
+	}
+
+	matrix, ok := val.(model.Matrix)
+	if !ok {
+		return errors.Errorf("range query returned unexpected type %T", val)
+	}
+	for _, series := range matrix {
+		l := metricToLabels(series.Metric, recordName, staticLabels)
+		var ref storage.SeriesRef
+		for _, p := range series.Values {
+			if ref, err = app.Append(ref, l, int64(p.Timestamp), float64(p.Value)); err != nil {
+				return errors.Wrap(err, "add sample")
+			}
+		}
+	}
+	return nil
+}
+
+// metricToLabels builds the label set a backfilled sample is stored under: the query
+// result's labels, with __name__ forced to recordName and staticLabels (the rule's
+// own `labels:` block) overlaid on top, matching how Prometheus' RecordingRule.Eval
+// stamps series it produces.
+func metricToLabels(m model.Metric, recordName string, staticLabels map[string]string) labels.Labels {
+	b := labels.NewBuilder(labels.EmptyLabels())
+	for n, v := range m {
+		b.Set(string(n), string(v))
+	}
+	b.Set(labels.MetricName, recordName)
+	for n, v := range staticLabels {
+		b.Set(n, v)
+	}
+	return b.Labels()
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/yangtaoran/thanos-kit/backfill/rules"
+	"github.com/yangtaoran/thanos-kit/importer/blocks"
+)
+
+func registerBackfill(app *kingpin.Application, cmds map[string]setupFunc) {
+	cmd := app.Command("backfill", "Backfill data that isn't produced by scraping.")
+	registerBackfillRules(cmd, cmds)
+}
+
+func registerBackfillRules(parent *kingpin.CmdClause, cmds map[string]setupFunc) {
+	cmd := parent.Command("rules", "Backfill recording rules by evaluating them against a Prometheus/Thanos API over a historical range.")
+
+	url := cmd.Flag("url", "Base URL of the Prometheus/Thanos query API to evaluate rules against.").Required().String()
+	start := cmd.Flag("start", "Start of the range to backfill, RFC3339.").Required().String()
+	end := cmd.Flag("end", "End of the range to backfill, RFC3339.").Required().String()
+	evalInterval := cmd.Flag("eval-interval", "Step used for each rule's range query.").Default("60s").Duration()
+	outputDir := cmd.Flag("output-dir", "Directory to write resulting block(s) into.").Default("./data").String()
+	blockDuration := cmd.Flag("block-duration", "Duration of each output block.").Default("2h").Duration()
+	ruleFiles := cmd.Arg("rule-files", "Rule files to backfill recording rules from.").Required().ExistingFiles()
+
+	cmds[cmd.FullCommand()] = func(logger log.Logger) error {
+		return runBackfillRules(logger, *url, *start, *end, *evalInterval, *outputDir, *blockDuration, *ruleFiles)
+	}
+}
+
+func runBackfillRules(
+	logger log.Logger,
+	url string,
+	startStr, endStr string,
+	evalInterval time.Duration,
+	outputDir string,
+	blockDuration time.Duration,
+	ruleFiles []string,
+) error {
+	start, err := time.Parse(time.RFC3339, startStr)
+	if err != nil {
+		return errors.Wrap(err, "parse --start")
+	}
+	end, err := time.Parse(time.RFC3339, endStr)
+	if err != nil {
+		return errors.Wrap(err, "parse --end")
+	}
+
+	cfg := rules.Config{
+		URL:          url,
+		Start:        start,
+		End:          end,
+		EvalInterval: evalInterval,
+		RuleFiles:    ruleFiles,
+	}
+
+	sizeMillis := blocks.DurToMillis(blockDuration)
+	w := blocks.NewMultiWriter(logger, outputDir, sizeMillis, 0, labels.EmptyLabels(), 0, 0)
+
+	ids, err := rules.Run(context.Background(), logger, cfg, sizeMillis, w)
+	if err != nil {
+		return errors.Wrap(err, "backfill rules")
+	}
+	level.Info(logger).Log("msg", "backfill complete", "blocks", len(ids))
+	return nil
+}
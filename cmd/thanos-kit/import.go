@@ -0,0 +1,217 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/thanos-io/thanos/pkg/objstore"
+	"github.com/thanos-io/thanos/pkg/objstore/client"
+	"gopkg.in/alecthomas/kingpin.v2"
+
+	"github.com/yangtaoran/thanos-kit/importer"
+	"github.com/yangtaoran/thanos-kit/importer/blocks"
+	"github.com/yangtaoran/thanos-kit/importer/postprocess"
+)
+
+func registerImport(app *kingpin.Application, cmds map[string]setupFunc) {
+	cmd := app.Command("import", "Import an exposition-format dump into TSDB block(s).")
+
+	inputFile := cmd.Arg("input-file", "File to read input from; reads stdin if not set.").String()
+	format := cmd.Flag("format", "Exposition format of the input.").
+		Default(string(importer.FormatProm)).Enum(string(importer.FormatProm), string(importer.FormatOpenMetrics))
+	twoPass := cmd.Flag("two-pass", "Do a first pass over the input to discover its time range before appending, "+
+		"so the resulting block(s) align exactly to the data instead of including the writer's default bounds.").Bool()
+	outputDir := cmd.Flag("output-dir", "Directory to write resulting block(s) into.").Default("./data").String()
+	blockDuration := cmd.Flag("block-duration", "Duration of each output block.").Default("2h").Duration()
+	seriesBudget := cmd.Flag("series-budget", "Max in-memory series per block range before it is proactively flushed.").
+		Default("0").Int64()
+	flushConcurrency := cmd.Flag("flush-concurrency", "Max number of block ranges flushed concurrently; 0 means the number of CPUs.").
+		Default("0").Int()
+	extLabels := cmd.Flag("label", "External label to set on every output block, in key=value form. Repeatable.").
+		Strings()
+	postProcessFlag := cmd.Flag("post-process", "Comma-separated post-processing steps to run on every output block, "+
+		"in order: label, downsample, upload.").String()
+	postProcessLabels := cmd.Flag("post-process.label", "Label the \"label\" post-process step injects into every block "+
+		"it processes, in key=value form. Repeatable; required if --post-process includes label.").Strings()
+	downsampleResolutions := cmd.Flag("post-process.downsample-resolutions", "Resolutions the \"downsample\" post-process "+
+		"step produces, as a comma-separated list of durations.").Default("5m,1h").String()
+	objstoreConfigFile := cmd.Flag("objstore.config-file", "Path to YAML objstore config; required if --post-process includes upload.").
+		String()
+
+	cmds[cmd.FullCommand()] = func(logger log.Logger) error {
+		return runImport(logger, *inputFile, importer.Format(*format), *twoPass, *outputDir, *blockDuration,
+			*seriesBudget, *flushConcurrency, *extLabels, *postProcessFlag, *postProcessLabels, *downsampleResolutions, *objstoreConfigFile)
+	}
+}
+
+func runImport(
+	logger log.Logger,
+	inputFile string,
+	format importer.Format,
+	twoPass bool,
+	outputDir string,
+	blockDuration time.Duration,
+	seriesBudget int64,
+	flushConcurrency int,
+	extLabels []string,
+	postProcessFlag string,
+	postProcessLabels []string,
+	downsampleResolutions string,
+	objstoreConfigFile string,
+) error {
+	b, err := readInput(inputFile)
+	if err != nil {
+		return errors.Wrap(err, "read input")
+	}
+
+	lbls, err := parseLabels(extLabels)
+	if err != nil {
+		return errors.Wrap(err, "parse --label")
+	}
+
+	postProcessors, err := buildPostProcessors(logger, postProcessFlag, postProcessLabels, downsampleResolutions, objstoreConfigFile)
+	if err != nil {
+		return errors.Wrap(err, "build post-processors")
+	}
+
+	sizeMillis := blocks.DurToMillis(blockDuration)
+
+	// alignMint anchors block range boundaries at the epoch by default. With
+	// --two-pass, we discover the input's own mint first and anchor there instead,
+	// so the resulting block(s) align exactly to the data, matching how two-pass
+	// OpenMetrics backfill is done upstream.
+	var alignMint int64
+	if twoPass {
+		p, err := importer.NewParser(format, b)
+		if err != nil {
+			return errors.Wrap(err, "new parser")
+		}
+		mint, maxt, err := importer.DiscoverTimeRange(p)
+		if err != nil {
+			return errors.Wrap(err, "discover time range")
+		}
+		level.Info(logger).Log("msg", "discovered input time range", "mint", mint, "maxt", maxt)
+		alignMint = mint
+	}
+
+	p, err := importer.NewParser(format, b)
+	if err != nil {
+		return errors.Wrap(err, "new parser")
+	}
+
+	w := blocks.NewMultiWriter(logger, outputDir, sizeMillis, alignMint, lbls, seriesBudget, flushConcurrency, postProcessors...)
+	ids, err := importer.Import(logger, p, w)
+	if err != nil {
+		return errors.Wrap(err, "import")
+	}
+	level.Info(logger).Log("msg", "import complete", "blocks", len(ids))
+	return nil
+}
+
+func readInput(inputFile string) ([]byte, error) {
+	if inputFile == "" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+	return ioutil.ReadFile(inputFile)
+}
+
+// parseLabels parses key=value external label flags into a sorted labels.Labels.
+func parseLabels(kvs []string) (labels.Labels, error) {
+	b := labels.NewBuilder(labels.EmptyLabels())
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return labels.EmptyLabels(), errors.Errorf("invalid --label %q, expected key=value", kv)
+		}
+		b.Set(parts[0], parts[1])
+	}
+	return b.Labels(), nil
+}
+
+// buildPostProcessors turns --post-process=label,downsample,upload into the
+// corresponding ordered blocks.BlockPostProcessor chain.
+func buildPostProcessors(logger log.Logger, postProcessFlag string, postProcessLabels []string, downsampleResolutions string, objstoreConfigFile string) ([]blocks.BlockPostProcessor, error) {
+	if postProcessFlag == "" {
+		return nil, nil
+	}
+
+	var (
+		pps    []blocks.BlockPostProcessor
+		bucket objstore.Bucket
+	)
+	for _, step := range strings.Split(postProcessFlag, ",") {
+		switch strings.TrimSpace(step) {
+		case "label":
+			injected, err := parseLabelMap(postProcessLabels)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse --post-process.label")
+			}
+			if len(injected) == 0 {
+				return nil, errors.New("--post-process=label requires at least one --post-process.label")
+			}
+			pps = append(pps, postprocess.LabelInjector{Labels: injected})
+		case "downsample":
+			resolutions, err := parseDownsampleResolutions(downsampleResolutions)
+			if err != nil {
+				return nil, errors.Wrap(err, "parse --post-process.downsample-resolutions")
+			}
+			pps = append(pps, postprocess.Downsampler{Logger: logger, Resolutions: resolutions})
+		case "upload":
+			if bucket == nil {
+				confContentYaml, err := ioutil.ReadFile(objstoreConfigFile)
+				if err != nil {
+					return nil, errors.Wrap(err, "read --objstore.config-file")
+				}
+				bucket, err = client.NewBucket(logger, confContentYaml, "thanos-kit")
+				if err != nil {
+					return nil, errors.Wrap(err, "new bucket client")
+				}
+			}
+			pps = append(pps, postprocess.ObjstoreUploader{Logger: logger, Bucket: bucket})
+		default:
+			return nil, errors.Errorf("unknown --post-process step %q, expected one of label, downsample, upload", step)
+		}
+	}
+	return pps, nil
+}
+
+// parseLabelMap parses key=value flags into a map, for post-processors that take
+// labels as a map rather than a sorted labels.Labels.
+func parseLabelMap(kvs []string) (map[string]string, error) {
+	m := make(map[string]string, len(kvs))
+	for _, kv := range kvs {
+		parts := strings.SplitN(kv, "=", 2)
+		if len(parts) != 2 || parts[0] == "" {
+			return nil, errors.Errorf("invalid label %q, expected key=value", kv)
+		}
+		m[parts[0]] = parts[1]
+	}
+	return m, nil
+}
+
+// parseDownsampleResolutions parses a comma-separated list of durations (e.g.
+// "5m,1h") into the millisecond resolutions Downsampler expects.
+func parseDownsampleResolutions(s string) ([]int64, error) {
+	var resolutions []int64
+	for _, r := range strings.Split(s, ",") {
+		r = strings.TrimSpace(r)
+		if r == "" {
+			continue
+		}
+		d, err := time.ParseDuration(r)
+		if err != nil {
+			return nil, errors.Wrapf(err, "invalid resolution %q", r)
+		}
+		resolutions = append(resolutions, blocks.DurToMillis(d))
+	}
+	if len(resolutions) == 0 {
+		return nil, errors.New("at least one resolution is required")
+	}
+	return resolutions, nil
+}
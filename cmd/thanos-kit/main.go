@@ -0,0 +1,55 @@
+// Command thanos-kit provides standalone tooling for working with
+// Prometheus/Thanos TSDB blocks outside of a running Prometheus or Thanos
+// process: bulk-importing exposition-format dumps, and backfilling recording
+// rules against a historical range.
+package main
+
+import (
+	"os"
+
+	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
+	"gopkg.in/alecthomas/kingpin.v2"
+)
+
+// setupFunc runs the command registered under its kingpin.FullCommand() key.
+type setupFunc func(logger log.Logger) error
+
+func main() {
+	app := kingpin.New("thanos-kit", "Tooling for Prometheus/Thanos TSDB blocks.")
+	logLevel := app.Flag("log.level", "Log filtering level.").
+		Default("info").Enum("debug", "info", "warn", "error")
+
+	cmds := map[string]setupFunc{}
+	registerImport(app, cmds)
+	registerBackfill(app, cmds)
+
+	cmd, err := app.Parse(os.Args[1:])
+	if err != nil {
+		kingpin.Fatalf("parse command line: %v", err)
+	}
+
+	logger := newLogger(*logLevel)
+	if err := cmds[cmd](logger); err != nil {
+		level.Error(logger).Log("msg", "error running command", "cmd", cmd, "err", err)
+		os.Exit(1)
+	}
+}
+
+func newLogger(logLevel string) log.Logger {
+	logger := log.NewLogfmtLogger(log.NewSyncWriter(os.Stderr))
+	var opt level.Option
+	switch logLevel {
+	case "debug":
+		opt = level.AllowDebug()
+	case "warn":
+		opt = level.AllowWarn()
+	case "error":
+		opt = level.AllowError()
+	default:
+		opt = level.AllowInfo()
+	}
+	logger = level.NewFilter(logger, opt)
+	logger = log.With(logger, "ts", log.DefaultTimestampUTC, "caller", log.DefaultCaller)
+	return logger
+}
@@ -16,11 +16,16 @@ package blocks
 
 import (
 	"context"
+	"runtime"
+	"sort"
+	"sync"
 
 	"github.com/go-kit/kit/log"
+	"github.com/go-kit/kit/log/level"
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
 	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/storage"
 	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
@@ -33,6 +38,14 @@ func (a errAppender) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e ex
 	return 0, a.err
 }
 
+func (a errAppender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	return 0, a.err
+}
+
+func (a errAppender) AppendCTZeroSample(ref storage.SeriesRef, l labels.Labels, t, ct int64) (storage.SeriesRef, error) {
+	return 0, a.err
+}
+
 func (a errAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
 	return 0, a.err
 }
@@ -45,29 +58,65 @@ func (a errAppender) Rollback() error {
 	return a.err
 }
 
-func rangeForTimestamp(t int64, width int64) (maxt int64) {
-	return (t/width)*width + width
+// rangeForTimestamp returns the end of the width-sized bucket t falls into, with
+// bucket boundaries anchored at alignment instead of the epoch. Passing 0 for
+// alignment reproduces the original epoch-anchored bucketing.
+func rangeForTimestamp(t, width, alignment int64) (maxt int64) {
+	shifted := t - alignment
+	return alignment + (shifted/width)*width + width
 }
 
+// DefaultSeriesBudget is the default number of series an individual range's writer
+// may buffer in memory before MultiWriter proactively flushes it.
+const DefaultSeriesBudget = 1_000_000
+
 type MultiWriter struct {
 	blocks          map[index.Range]Writer
 	activeAppenders map[index.Range]storage.Appender
+	// flushedIDs accumulates ULIDs of blocks flushed by seriesBudget-driven partial
+	// flushes, i.e. ones produced before the final Flush call.
+	flushedIDs []ulid.ULID
 
 	logger log.Logger
 	dir    string
 	// TODO(bwplotka): Allow more complex compaction levels.
 	sizeMillis int64
-	labels     labels.Labels
+	// alignMint anchors block range boundaries to this timestamp instead of the
+	// epoch, e.g. so a two-pass import's blocks align exactly to its input's own
+	// time range rather than wherever the epoch-anchored grid happens to fall.
+	alignMint        int64
+	labels           labels.Labels
+	seriesBudget     int64
+	flushConcurrency int
+	postProcessors   []BlockPostProcessor
 }
 
-func NewMultiWriter(logger log.Logger, dir string, sizeMillis int64, lbls labels.Labels) *MultiWriter {
+// NewMultiWriter creates a MultiWriter that shards appended series into TSDBWriters
+// per sizeMillis-sized time range, with range boundaries anchored at alignMint
+// (pass 0 to anchor at the epoch). seriesBudget bounds how many series an individual
+// range's writer may buffer before it is automatically flushed to keep memory use
+// roughly constant regardless of input size; pass 0 to use DefaultSeriesBudget.
+// flushConcurrency bounds how many per-range writers are flushed, committed, or
+// rolled back at once; pass 0 to use runtime.NumCPU(). Every block produced by any
+// of its writers is run through postProcessors.
+func NewMultiWriter(logger log.Logger, dir string, sizeMillis, alignMint int64, lbls labels.Labels, seriesBudget int64, flushConcurrency int, postProcessors ...BlockPostProcessor) *MultiWriter {
+	if seriesBudget <= 0 {
+		seriesBudget = DefaultSeriesBudget
+	}
+	if flushConcurrency <= 0 {
+		flushConcurrency = runtime.NumCPU()
+	}
 	return &MultiWriter{
-		logger:          logger,
-		dir:             dir,
-		sizeMillis:      sizeMillis,
-		labels:          lbls,
-		blocks:          map[index.Range]Writer{},
-		activeAppenders: map[index.Range]storage.Appender{},
+		logger:           logger,
+		dir:              dir,
+		sizeMillis:       sizeMillis,
+		alignMint:        alignMint,
+		labels:           lbls,
+		seriesBudget:     seriesBudget,
+		flushConcurrency: flushConcurrency,
+		postProcessors:   postProcessors,
+		blocks:           map[index.Range]Writer{},
+		activeAppenders:  map[index.Range]storage.Appender{},
 	}
 }
 
@@ -77,14 +126,17 @@ func (w *MultiWriter) Appender(ctx context.Context) storage.Appender {
 	return w
 }
 
-func (w *MultiWriter) getOrCreate(t int64) storage.Appender {
-	maxt := rangeForTimestamp(t, w.sizeMillis)
-	hash := index.Range{Start: maxt - w.sizeMillis, End: maxt}
+func (w *MultiWriter) hashFor(t int64) index.Range {
+	maxt := rangeForTimestamp(t, w.sizeMillis, w.alignMint)
+	return index.Range{Start: maxt - w.sizeMillis, End: maxt}
+}
+
+func (w *MultiWriter) getOrCreate(hash index.Range) storage.Appender {
 	if a, ok := w.activeAppenders[hash]; ok {
 		return a
 	}
 
-	nw, err := NewTSDBWriter(w.logger, w.dir, w.labels)
+	nw, err := NewTSDBWriter(w.logger, w.dir, w.labels, w.postProcessors...)
 	if err != nil {
 		return errAppender{err: errors.Wrap(err, "new tsdb writer")}
 	}
@@ -94,39 +146,140 @@ func (w *MultiWriter) getOrCreate(t int64) storage.Appender {
 	return w.activeAppenders[hash]
 }
 
+// maybeFlush commits and flushes the writer for hash once it has buffered at least
+// seriesBudget series, then opens a fresh appender for it so ingestion can continue.
+func (w *MultiWriter) maybeFlush(hash index.Range) error {
+	b, ok := w.blocks[hash]
+	if !ok || b.NumSeries() < uint64(w.seriesBudget) {
+		return nil
+	}
+
+	level.Info(w.logger).Log("msg", "series budget reached, partially flushing range", "range", hash, "series_budget", w.seriesBudget)
+	if err := w.activeAppenders[hash].Commit(); err != nil {
+		return errors.Wrap(err, "commit before partial flush")
+	}
+	// Append doesn't carry a context of its own (storage.Appender has none), so this
+	// budget-driven partial flush has nothing more specific to propagate.
+	ids, err := b.Flush(context.Background())
+	if err != nil {
+		return errors.Wrap(err, "partial flush")
+	}
+	w.flushedIDs = append(w.flushedIDs, ids...)
+	w.activeAppenders[hash] = b.Appender(context.Background())
+	return nil
+}
+
 func (w *MultiWriter) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
-	return w.getOrCreate(e.Ts).AppendExemplar(ref, l, e)
+	hash := w.hashFor(e.Ts)
+	ref, err := w.getOrCreate(hash).AppendExemplar(ref, l, e)
+	if err != nil {
+		return ref, err
+	}
+	return ref, w.maybeFlush(hash)
 }
 
 func (w *MultiWriter) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
-	return w.getOrCreate(t).Append(ref, l, t, v)
+	hash := w.hashFor(t)
+	ref, err := w.getOrCreate(hash).Append(ref, l, t, v)
+	if err != nil {
+		return ref, err
+	}
+	return ref, w.maybeFlush(hash)
 }
 
-func (w *MultiWriter) Commit() error {
-	merr := tsdb_errors.NewMulti()
-	for _, a := range w.activeAppenders {
-		merr.Add(a.Commit())
+func (w *MultiWriter) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	hash := w.hashFor(t)
+	ref, err := w.getOrCreate(hash).AppendHistogram(ref, l, t, h, fh)
+	if err != nil {
+		return ref, err
 	}
-	return merr.Err()
+	return ref, w.maybeFlush(hash)
+}
+
+func (w *MultiWriter) AppendCTZeroSample(ref storage.SeriesRef, l labels.Labels, t, ct int64) (storage.SeriesRef, error) {
+	hash := w.hashFor(t)
+	ref, err := w.getOrCreate(hash).AppendCTZeroSample(ref, l, t, ct)
+	if err != nil {
+		return ref, err
+	}
+	return ref, w.maybeFlush(hash)
+}
+
+func (w *MultiWriter) Commit() error {
+	return w.forEachAppender(func(a storage.Appender) error { return a.Commit() })
 }
 
 func (w *MultiWriter) Rollback() error {
-	merr := tsdb_errors.NewMulti()
+	return w.forEachAppender(func(a storage.Appender) error { return a.Rollback() })
+}
+
+// forEachAppender runs fn against every active appender, bounded by
+// flushConcurrency concurrent goroutines, merging any errors.
+func (w *MultiWriter) forEachAppender(fn func(storage.Appender) error) error {
+	var (
+		wg   sync.WaitGroup
+		mtx  sync.Mutex
+		merr = tsdb_errors.NewMulti()
+		sem  = make(chan struct{}, w.flushConcurrency)
+	)
 	for _, a := range w.activeAppenders {
-		merr.Add(a.Rollback())
+		a := a
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			if err := fn(a); err != nil {
+				mtx.Lock()
+				merr.Add(err)
+				mtx.Unlock()
+			}
+		}()
 	}
+	wg.Wait()
 	return merr.Err()
 }
 
-func (w *MultiWriter) Flush() ([]ulid.ULID, error) {
-	ids := make([]ulid.ULID, 0, len(w.blocks))
+// Flush flushes every per-range writer that has buffered data, bounded by
+// flushConcurrency concurrent goroutines (which also bounds concurrent
+// tsdb.LeveledCompactor.Write calls, the expensive part of each Flush). The
+// returned ULIDs are sorted so callers get a deterministic result regardless of
+// which worker finishes first.
+func (w *MultiWriter) Flush(ctx context.Context) ([]ulid.ULID, error) {
+	var (
+		wg   sync.WaitGroup
+		mtx  sync.Mutex
+		ids  = append([]ulid.ULID{}, w.flushedIDs...)
+		merr = tsdb_errors.NewMulti()
+		sem  = make(chan struct{}, w.flushConcurrency)
+	)
 	for _, b := range w.blocks {
-		id, err := b.Flush()
-		if err != nil {
-			return nil, err
+		if b.NumSeries() == 0 {
+			// Already flushed by a seriesBudget-driven partial flush; nothing new to write.
+			continue
 		}
-		ids = append(ids, id...)
+		b := b
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+			blockIDs, err := b.Flush(ctx)
+			mtx.Lock()
+			defer mtx.Unlock()
+			if err != nil {
+				merr.Add(err)
+				return
+			}
+			ids = append(ids, blockIDs...)
+		}()
 	}
+	wg.Wait()
+	if err := merr.Err(); err != nil {
+		return nil, err
+	}
+
+	sort.Slice(ids, func(i, j int) bool { return ids[i].Compare(ids[j]) < 0 })
 	return ids, nil
 }
 
@@ -39,9 +39,13 @@ import (
 type Writer interface {
 	storage.Appendable
 
-	// Flush writes current data to disk.
-	// The block or blocks will contain values accumulated by `Write`.
-	Flush() ([]ulid.ULID, error)
+	// NumSeries reports the number of distinct series currently buffered in memory.
+	NumSeries() uint64
+
+	// Flush writes the data accumulated since the writer was created, or since the
+	// last Flush, to disk as a block. The writer remains usable for further appends;
+	// call Close once no more data will be written.
+	Flush(ctx context.Context) ([]ulid.ULID, error)
 
 	// Close releases all resources. No append is allowed anymore to such writer.
 	Close() error
@@ -49,11 +53,23 @@ type Writer interface {
 
 var _ Writer = &TSDBWriter{}
 
+// BlockPostProcessor runs against a block right after TSDBWriter has written and
+// meta-patched it. Implementations can mutate the block in place (e.g. inject
+// labels), ship it elsewhere (e.g. upload to object storage), or produce
+// additional sibling blocks (e.g. downsample), returning the directories of any
+// such blocks so the remaining post-processors in the chain run over those too.
+// Post-processors run in the order they were supplied to
+// NewTSDBWriter/NewMultiWriter.
+type BlockPostProcessor interface {
+	Process(ctx context.Context, blockDir string, meta *metadata.Meta) (extraBlockDirs []string, err error)
+}
+
 // Writer is a block writer that allows appending and flushing to disk.
 type TSDBWriter struct {
-	logger log.Logger
-	dir    string
-	labels labels.Labels
+	logger         log.Logger
+	dir            string
+	labels         labels.Labels
+	postProcessors []BlockPostProcessor
 
 	head   *tsdb.Head
 	tmpDir string
@@ -65,17 +81,22 @@ func DurToMillis(t time.Duration) int64 {
 
 // NewTSDBWriter create new block writer.
 //
-// The returned writer accumulates all series in memory until `Flush` is called.
+// The returned writer accumulates series in memory until `Flush` is called. Flush
+// writes out a block and rotates to a fresh, empty head, so a writer can be reused
+// across many Flush calls without its memory footprint growing unbounded.
+//
+// Each flushed block is run through postProcessors, in order, before Flush returns.
 //
 // Note that the writer will not check if the target directory exists or
 // contains anything at all. It is the caller's responsibility to
 // ensure that the resulting blocks do not overlap etc.
 // Writer ensures the block flush is atomic (via rename).
-func NewTSDBWriter(logger log.Logger, dir string, labels labels.Labels) (*TSDBWriter, error) {
+func NewTSDBWriter(logger log.Logger, dir string, labels labels.Labels, postProcessors ...BlockPostProcessor) (*TSDBWriter, error) {
 	res := &TSDBWriter{
-		logger: logger,
-		dir:    dir,
-		labels: labels,
+		logger:         logger,
+		dir:            dir,
+		labels:         labels,
+		postProcessors: postProcessors,
 	}
 	return res, res.initHead()
 }
@@ -96,6 +117,9 @@ func (w *TSDBWriter) initHead() error {
 	opts := tsdb.DefaultHeadOptions()
 	opts.ChunkRange = DurToMillis(9999 * time.Hour)
 	opts.ChunkDirRoot = w.tmpDir
+	// Allow native histogram samples through the appender, for input whose parser
+	// emits them.
+	opts.EnableNativeHistograms = true
 	h, err := tsdb.NewHead(nil, logger, nil, opts, tsdb.NewHeadStats())
 	if err != nil {
 		return errors.Wrap(err, "tsdb.NewHead")
@@ -110,11 +134,15 @@ func (w *TSDBWriter) Appender(ctx context.Context) storage.Appender {
 	return w.head.Appender(ctx)
 }
 
-// Flush implements Writer interface. This is where actual block writing
-// happens. After flush completes, no write can be done.
-func (w *TSDBWriter) Flush() ([]ulid.ULID, error) {
+// NumSeries implements Writer interface.
+func (w *TSDBWriter) NumSeries() uint64 {
+	return w.head.NumSeries()
+}
+
+// Flush implements Writer interface. This is where actual block writing happens.
+func (w *TSDBWriter) Flush(ctx context.Context) ([]ulid.ULID, error) {
 	seriesCount := w.head.NumSeries()
-	if w.head.NumSeries() == 0 {
+	if seriesCount == 0 {
 		return nil, errors.New("no series appended; aborting.")
 	}
 
@@ -137,19 +165,69 @@ func (w *TSDBWriter) Flush() ([]ulid.ULID, error) {
 	if err != nil {
 		return nil, errors.Wrap(err, "compactor write")
 	}
-	// TODO(bwplotka): Potential truncate head, and allow writer reuse. Currently truncating fails with
-	// truncate chunks.HeadReadWriter: maxt of the files are not set.
 
-	meta, err := metadata.ReadFromDir(filepath.Join(w.dir, id.String()))
+	blockDir := filepath.Join(w.dir, id.String())
+	meta, err := metadata.ReadFromDir(blockDir)
 	if err != nil {
 		return nil, errors.Wrap(err, "metadata read")
 	}
 	meta.Thanos.Source = "thanos-kit"
 	meta.Thanos.Labels = w.labels.Map()
-	if err = meta.WriteToDir(w.logger, filepath.Join(w.dir, id.String())); err != nil {
+	if err = meta.WriteToDir(w.logger, blockDir); err != nil {
 		return nil, errors.Wrap(err, "metadata write")
 	}
-	return []ulid.ULID{id}, nil
+
+	// Run every post-processor over blockDir, and over any additional blocks a
+	// post-processor produces along the way (e.g. a Downsampler's 5m/1h siblings),
+	// so that later steps (e.g. an uploader) see those too. Re-persist meta.json
+	// after every step, not just once at the end: a processor may mutate meta
+	// (e.g. inject labels) and a later one reads the block directory as the
+	// source of truth, not our in-memory meta.
+	type pendingBlock struct {
+		dir  string
+		meta *metadata.Meta
+	}
+	pending := []pendingBlock{{dir: blockDir, meta: meta}}
+	ids := []ulid.ULID{id}
+	for _, pp := range w.postProcessors {
+		var next []pendingBlock
+		for _, pb := range pending {
+			extraDirs, err := pp.Process(ctx, pb.dir, pb.meta)
+			if err != nil {
+				return nil, errors.Wrap(err, "post process block")
+			}
+			if err := pb.meta.WriteToDir(w.logger, pb.dir); err != nil {
+				return nil, errors.Wrap(err, "metadata write after post process")
+			}
+			next = append(next, pb)
+
+			for _, dir := range extraDirs {
+				m, err := metadata.ReadFromDir(dir)
+				if err != nil {
+					return nil, errors.Wrap(err, "read post-processed block metadata")
+				}
+				next = append(next, pendingBlock{dir: dir, meta: m})
+				ids = append(ids, m.ULID)
+			}
+		}
+		pending = next
+	}
+
+	// head.Truncate alone leaves "maxt of the files are not set" because our huge
+	// ChunkRange means the chunk-writer never cuts its current file, so instead of
+	// truncating in place we close the now-compacted head and spin up a fresh one.
+	// That bounds memory the same way truncation would, without fighting the
+	// chunk-writer's own file-cutting assumptions.
+	oldHead, oldTmpDir := w.head, w.tmpDir
+	if err := w.initHead(); err != nil {
+		return nil, errors.Wrap(err, "reinit head after flush")
+	}
+	if err := oldHead.Close(); err != nil {
+		return nil, errors.Wrap(err, "close flushed head")
+	}
+	_ = os.RemoveAll(oldTmpDir)
+
+	return ids, nil
 }
 
 func (w *TSDBWriter) Close() error {
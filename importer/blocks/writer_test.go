@@ -0,0 +1,61 @@
+package blocks
+
+import (
+	"context"
+	"testing"
+
+	"github.com/go-kit/kit/log"
+	"github.com/prometheus/prometheus/model/labels"
+)
+
+// TestTSDBWriter_Flush_Twice verifies the head-rotation behavior Flush relies on to
+// bound memory: after Flush writes a block, the writer must still be appendable,
+// and a second round of appends followed by another Flush must produce a distinct
+// block rather than reusing or corrupting the first one.
+func TestTSDBWriter_Flush_Twice(t *testing.T) {
+	dir := t.TempDir()
+	w, err := NewTSDBWriter(log.NewNopLogger(), dir, labels.EmptyLabels())
+	if err != nil {
+		t.Fatalf("NewTSDBWriter: %v", err)
+	}
+	defer func() { _ = w.Close() }()
+
+	app := w.Appender(context.Background())
+	if _, err := app.Append(0, labels.FromStrings("__name__", "first"), 1000, 1); err != nil {
+		t.Fatalf("append: %v", err)
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatalf("commit: %v", err)
+	}
+
+	firstIDs, err := w.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("first Flush: %v", err)
+	}
+	if len(firstIDs) != 1 {
+		t.Fatalf("expected 1 block from first Flush, got %d", len(firstIDs))
+	}
+
+	if w.NumSeries() != 0 {
+		t.Fatalf("expected head to be empty right after rotation, got %d series", w.NumSeries())
+	}
+
+	app = w.Appender(context.Background())
+	if _, err := app.Append(0, labels.FromStrings("__name__", "second"), 2000, 2); err != nil {
+		t.Fatalf("append after rotation: %v", err)
+	}
+	if err := app.Commit(); err != nil {
+		t.Fatalf("commit after rotation: %v", err)
+	}
+
+	secondIDs, err := w.Flush(context.Background())
+	if err != nil {
+		t.Fatalf("second Flush: %v", err)
+	}
+	if len(secondIDs) != 1 {
+		t.Fatalf("expected 1 block from second Flush, got %d", len(secondIDs))
+	}
+	if secondIDs[0] == firstIDs[0] {
+		t.Fatalf("expected the second Flush to produce a distinct block, got the same id %s twice", firstIDs[0])
+	}
+}
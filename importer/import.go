@@ -19,17 +19,42 @@ import (
 	"fmt"
 	"github.com/prometheus/prometheus/storage"
 	"io"
+	"math"
 
 	"github.com/go-kit/kit/log"
 	"github.com/go-kit/kit/log/level"
 	"github.com/oklog/ulid"
 	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/model/exemplar"
 	"github.com/prometheus/prometheus/model/labels"
 	"github.com/prometheus/prometheus/model/textparse"
 	tsdb_errors "github.com/prometheus/prometheus/tsdb/errors"
 	"github.com/yangtaoran/thanos-kit/importer/blocks"
 )
 
+// Format is the exposition format of the data being imported.
+type Format string
+
+const (
+	// FormatProm is the classic Prometheus text exposition format.
+	FormatProm Format = "prom"
+	// FormatOpenMetrics is the OpenMetrics exposition format. It additionally
+	// carries exemplars, created-timestamps, and native histograms.
+	FormatOpenMetrics Format = "openmetrics"
+)
+
+// NewParser returns a textparse.Parser for the given exposition format.
+func NewParser(format Format, b []byte) (textparse.Parser, error) {
+	switch format {
+	case FormatOpenMetrics:
+		return textparse.NewOpenMetricsParser(b, labels.NewSymbolTable()), nil
+	case FormatProm, "":
+		return textparse.NewPromParser(b, labels.NewSymbolTable()), nil
+	default:
+		return nil, errors.Errorf("unknown format %q, expected %q or %q", format, FormatProm, FormatOpenMetrics)
+	}
+}
+
 // Import imports data from a textparse Parser into block Writer.
 // TODO(bwplotka): textparse interface potentially limits the format to never give multiple samples. Fix this as some formats
 // (e.g JSON) might allow that.
@@ -52,6 +77,7 @@ func Import(logger log.Logger, p textparse.Parser, w blocks.Writer) (ids []ulid.
 	var (
 		e   textparse.Entry
 		ref storage.SeriesRef
+		l   labels.Labels
 	)
 	for {
 		e, err = p.Next()
@@ -62,20 +88,47 @@ func Import(logger log.Logger, p textparse.Parser, w blocks.Writer) (ids []ulid.
 			return nil, errors.Wrap(err, "parse")
 		}
 
-		// For now care about series only.
-		if e != textparse.EntrySeries {
+		l = labels.Labels{}
+		var sampleTs int64
+		switch e {
+		case textparse.EntrySeries:
+			p.Metric(&l)
+			_, ts, v := p.Series()
+			if ts == nil {
+				return nil, errors.Errorf("expected timestamp for series %v, got none", l.String())
+			}
+			sampleTs = *ts
+			if ref, err = app.Append(ref, l, *ts, v); err != nil {
+				return nil, errors.Wrap(err, "add sample")
+			}
+		case textparse.EntryHistogram:
+			p.Metric(&l)
+			_, ts, h, fh := p.Histogram()
+			if ts == nil {
+				return nil, errors.Errorf("expected timestamp for histogram series %v, got none", l.String())
+			}
+			sampleTs = *ts
+			if ref, err = app.AppendHistogram(ref, l, *ts, h, fh); err != nil {
+				return nil, errors.Wrap(err, "add histogram")
+			}
+		default:
+			// Comments, metadata (HELP/TYPE/UNIT) etc. carry no sample to append.
 			continue
 		}
 
-		// TODO(bwplotka): Avoid allocations using AddFast method and maintaining refs.
-		l := labels.Labels{}
-		p.Metric(&l)
-		_, ts, v := p.Series()
-		if ts == nil {
-			return nil, errors.Errorf("expected timestamp for series %v, got none", l.String())
+		// OpenMetrics input may carry a per-series created-timestamp; record it as a
+		// zero sample so counter resets across the series' lifetime stay accurate.
+		if ct := p.CreatedTimestamp(); ct != nil {
+			if ref, err = app.AppendCTZeroSample(ref, l, sampleTs, *ct); err != nil {
+				return nil, errors.Wrap(err, "add created timestamp")
+			}
 		}
-		if ref, err = app.Append(ref, l, *ts, v); err != nil {
-			return nil, errors.Wrap(err, "add sample")
+
+		var ex exemplar.Exemplar
+		if p.Exemplar(&ex) {
+			if ref, err = app.AppendExemplar(ref, l, ex); err != nil {
+				return nil, errors.Wrap(err, "add exemplar")
+			}
 		}
 	}
 
@@ -84,10 +137,56 @@ func Import(logger log.Logger, p textparse.Parser, w blocks.Writer) (ids []ulid.
 		return nil, errors.Wrap(err, "commit")
 	}
 
-	ids, err = w.Flush()
+	ids, err = w.Flush(context.Background())
 	if err != nil {
 		return nil, errors.Wrap(err, "flush")
 	}
 	level.Info(logger).Log("msg", "blocks flushed", "ids", fmt.Sprintf("%v", ids))
 	return ids, nil
 }
+
+// DiscoverTimeRange performs a first pass over p, returning the min and max sample
+// timestamps seen without appending anything. Callers that need to align blocks to
+// the full input's time range before the (potentially much larger) appending pass
+// begins can use this, mirroring the two-pass backfill upstream promtool performs
+// for OpenMetrics input.
+func DiscoverTimeRange(p textparse.Parser) (mint, maxt int64, err error) {
+	mint, maxt = math.MaxInt64, math.MinInt64
+
+	var (
+		e  textparse.Entry
+		ts *int64
+	)
+	for {
+		e, err = p.Next()
+		if err == io.EOF {
+			err = nil
+			break
+		}
+		if err != nil {
+			return 0, 0, errors.Wrap(err, "parse")
+		}
+
+		switch e {
+		case textparse.EntrySeries:
+			_, ts, _ = p.Series()
+		case textparse.EntryHistogram:
+			_, ts, _, _ = p.Histogram()
+		default:
+			continue
+		}
+		if ts == nil {
+			continue
+		}
+		if *ts < mint {
+			mint = *ts
+		}
+		if *ts > maxt {
+			maxt = *ts
+		}
+	}
+	if mint > maxt {
+		return 0, 0, errors.New("no samples found")
+	}
+	return mint, maxt, nil
+}
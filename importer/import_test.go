@@ -0,0 +1,176 @@
+package importer_test
+
+import (
+	"context"
+	"io"
+	"testing"
+
+	"github.com/oklog/ulid"
+	"github.com/prometheus/common/model"
+	"github.com/prometheus/prometheus/model/exemplar"
+	"github.com/prometheus/prometheus/model/histogram"
+	"github.com/prometheus/prometheus/model/labels"
+	"github.com/prometheus/prometheus/model/textparse"
+	"github.com/prometheus/prometheus/storage"
+
+	"github.com/yangtaoran/thanos-kit/importer"
+)
+
+// scriptedStep is one Next() result a scriptedParser replays.
+type scriptedStep struct {
+	entry  textparse.Entry
+	metric labels.Labels
+	ts     *int64
+	val    float64
+	h      *histogram.Histogram
+	fh     *histogram.FloatHistogram
+	ex     *exemplar.Exemplar
+	ct     *int64
+}
+
+// scriptedParser is a textparse.Parser driven by a fixed list of steps, so tests can
+// exercise Import's entry-type dispatch without depending on exact exposition-format
+// textual syntax.
+type scriptedParser struct {
+	steps []scriptedStep
+	idx   int
+}
+
+// newScriptedParser returns a scriptedParser positioned before the first step, so the
+// first Next() call delivers steps[0].
+func newScriptedParser(steps []scriptedStep) *scriptedParser {
+	return &scriptedParser{steps: steps, idx: -1}
+}
+
+func (p *scriptedParser) Next() (textparse.Entry, error) {
+	p.idx++
+	if p.idx >= len(p.steps) {
+		return 0, io.EOF
+	}
+	return p.steps[p.idx].entry, nil
+}
+
+func (p *scriptedParser) cur() scriptedStep { return p.steps[p.idx] }
+
+func (p *scriptedParser) Series() ([]byte, *int64, float64) {
+	s := p.cur()
+	return nil, s.ts, s.val
+}
+
+func (p *scriptedParser) Histogram() ([]byte, *int64, *histogram.Histogram, *histogram.FloatHistogram) {
+	s := p.cur()
+	return nil, s.ts, s.h, s.fh
+}
+
+func (p *scriptedParser) Help() ([]byte, []byte)           { return nil, nil }
+func (p *scriptedParser) Type() ([]byte, model.MetricType) { return nil, "" }
+func (p *scriptedParser) Unit() ([]byte, []byte)           { return nil, nil }
+func (p *scriptedParser) Comment() []byte                  { return nil }
+func (p *scriptedParser) CreatedTimestamp() *int64         { return p.cur().ct }
+
+func (p *scriptedParser) Metric(l *labels.Labels) string {
+	*l = p.cur().metric
+	return p.cur().metric.String()
+}
+
+func (p *scriptedParser) Exemplar(e *exemplar.Exemplar) bool {
+	ex := p.cur().ex
+	if ex == nil {
+		return false
+	}
+	*e = *ex
+	return true
+}
+
+// recordingAppender implements storage.Appender, recording every call made to it.
+type recordingAppender struct {
+	samples    []labels.Labels
+	histograms []labels.Labels
+	exemplars  []exemplar.Exemplar
+	createdTss []int64
+	committed  bool
+	rolledBack bool
+}
+
+func (a *recordingAppender) Append(ref storage.SeriesRef, l labels.Labels, t int64, v float64) (storage.SeriesRef, error) {
+	a.samples = append(a.samples, l)
+	return 1, nil
+}
+
+func (a *recordingAppender) AppendHistogram(ref storage.SeriesRef, l labels.Labels, t int64, h *histogram.Histogram, fh *histogram.FloatHistogram) (storage.SeriesRef, error) {
+	a.histograms = append(a.histograms, l)
+	return 1, nil
+}
+
+func (a *recordingAppender) AppendExemplar(ref storage.SeriesRef, l labels.Labels, e exemplar.Exemplar) (storage.SeriesRef, error) {
+	a.exemplars = append(a.exemplars, e)
+	return ref, nil
+}
+
+func (a *recordingAppender) AppendCTZeroSample(ref storage.SeriesRef, l labels.Labels, t, ct int64) (storage.SeriesRef, error) {
+	a.createdTss = append(a.createdTss, ct)
+	return ref, nil
+}
+
+func (a *recordingAppender) Commit() error   { a.committed = true; return nil }
+func (a *recordingAppender) Rollback() error { a.rolledBack = true; return nil }
+
+// fakeWriter implements blocks.Writer around a single recordingAppender, so tests can
+// inspect exactly what Import appended without going through a real TSDB head.
+type fakeWriter struct {
+	app *recordingAppender
+}
+
+func (w *fakeWriter) Appender(ctx context.Context) storage.Appender { return w.app }
+func (w *fakeWriter) NumSeries() uint64                             { return uint64(len(w.app.samples) + len(w.app.histograms)) }
+func (w *fakeWriter) Flush(ctx context.Context) ([]ulid.ULID, error) {
+	return []ulid.ULID{ulid.MustParse("01ARZ3NDEKTSV4RRFFQ69G5FAV")}, nil
+}
+func (w *fakeWriter) Close() error { return nil }
+
+func TestImport_DispatchesEveryEntryType(t *testing.T) {
+	ts := func(ms int64) *int64 { return &ms }
+	ct := int64(1000)
+	p := newScriptedParser([]scriptedStep{
+		{entry: textparse.EntrySeries, metric: labels.FromStrings("__name__", "requests_total"), ts: ts(2000), val: 1, ct: &ct},
+		{entry: textparse.EntryHistogram, metric: labels.FromStrings("__name__", "latency_bucket"), ts: ts(3000), h: &histogram.Histogram{}},
+		{entry: textparse.EntrySeries, metric: labels.FromStrings("__name__", "requests_total"), ts: ts(4000), val: 2,
+			ex: &exemplar.Exemplar{Value: 2, Ts: 4000}},
+	})
+	app := &recordingAppender{}
+	w := &fakeWriter{app: app}
+
+	ids, err := importer.Import(nil, p, w)
+	if err != nil {
+		t.Fatalf("Import: %v", err)
+	}
+	if len(ids) != 1 {
+		t.Fatalf("expected 1 block, got %d", len(ids))
+	}
+	if len(app.samples) != 2 {
+		t.Fatalf("expected 2 samples appended, got %d", len(app.samples))
+	}
+	if len(app.histograms) != 1 {
+		t.Fatalf("expected 1 histogram appended, got %d", len(app.histograms))
+	}
+	if len(app.exemplars) != 1 {
+		t.Fatalf("expected 1 exemplar appended, got %d", len(app.exemplars))
+	}
+	if len(app.createdTss) != 1 || app.createdTss[0] != ct {
+		t.Fatalf("expected created-timestamp %d to be appended, got %v", ct, app.createdTss)
+	}
+	if !app.committed {
+		t.Fatalf("expected appender to be committed")
+	}
+}
+
+func TestImport_RequiresTimestamp(t *testing.T) {
+	p := newScriptedParser([]scriptedStep{
+		{entry: textparse.EntrySeries, metric: labels.FromStrings("__name__", "no_ts"), ts: nil},
+	})
+	w := &fakeWriter{app: &recordingAppender{}}
+
+	if _, err := importer.Import(nil, p, w); err == nil {
+		t.Fatal("expected an error for a series with no timestamp")
+	}
+}
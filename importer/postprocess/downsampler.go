@@ -0,0 +1,52 @@
+package postprocess
+
+import (
+	"context"
+	"path/filepath"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/prometheus/prometheus/tsdb"
+	"github.com/prometheus/prometheus/tsdb/chunkenc"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/compact/downsample"
+)
+
+// Downsampler runs Thanos downsampling against a freshly written block, producing
+// sibling 5m and/or 1h resolution blocks alongside the raw one in the same dir.
+type Downsampler struct {
+	Logger log.Logger
+	// Resolutions are the downsample.ResLevelN millisecond resolutions to produce,
+	// e.g. downsample.ResLevel1 (5m) and downsample.ResLevel2 (1h).
+	Resolutions []int64
+}
+
+// Process implements blocks.BlockPostProcessor. The downsampled blocks it produces
+// are returned as extraBlockDirs so that post-processors running after Downsampler
+// (e.g. an uploader) see them too, instead of only ever seeing the raw block.
+func (p Downsampler) Process(_ context.Context, blockDir string, meta *metadata.Meta) (extraBlockDirs []string, err error) {
+	logger := p.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	b, err := tsdb.OpenBlock(logger, blockDir, chunkenc.NewPool())
+	if err != nil {
+		return nil, errors.Wrap(err, "open block")
+	}
+	defer func() { _ = b.Close() }()
+
+	// downsample.Downsample creates the new block as a fresh directory under the dir
+	// we pass it; that must be blockDir's parent, or the downsampled block ends up
+	// nested inside the raw block's own directory instead of alongside it.
+	parentDir := filepath.Dir(blockDir)
+	var dirs []string
+	for _, res := range p.Resolutions {
+		id, err := downsample.Downsample(logger, meta, b, parentDir, res)
+		if err != nil {
+			return nil, errors.Wrapf(err, "downsample to resolution %d", res)
+		}
+		dirs = append(dirs, filepath.Join(parentDir, id.String()))
+	}
+	return dirs, nil
+}
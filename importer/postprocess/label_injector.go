@@ -0,0 +1,24 @@
+package postprocess
+
+import (
+	"context"
+
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+)
+
+// LabelInjector adds a fixed set of external labels to every block it processes,
+// on top of whatever labels the writer already set. Existing keys are overwritten.
+type LabelInjector struct {
+	Labels map[string]string
+}
+
+// Process implements blocks.BlockPostProcessor.
+func (p LabelInjector) Process(_ context.Context, _ string, meta *metadata.Meta) (extraBlockDirs []string, err error) {
+	if meta.Thanos.Labels == nil {
+		meta.Thanos.Labels = map[string]string{}
+	}
+	for k, v := range p.Labels {
+		meta.Thanos.Labels[k] = v
+	}
+	return nil, nil
+}
@@ -0,0 +1,32 @@
+package postprocess
+
+import (
+	"context"
+
+	"github.com/go-kit/kit/log"
+	"github.com/pkg/errors"
+	"github.com/thanos-io/thanos/pkg/block"
+	"github.com/thanos-io/thanos/pkg/block/metadata"
+	"github.com/thanos-io/thanos/pkg/objstore"
+)
+
+// ObjstoreUploader uploads a freshly written block to an objstore.Bucket (S3, GCS,
+// Azure, ...), letting a single `thanos-kit import` run ship blocks without a
+// sidecar.
+type ObjstoreUploader struct {
+	Logger log.Logger
+	Bucket objstore.Bucket
+}
+
+// Process implements blocks.BlockPostProcessor.
+func (p ObjstoreUploader) Process(ctx context.Context, blockDir string, meta *metadata.Meta) (extraBlockDirs []string, err error) {
+	logger := p.Logger
+	if logger == nil {
+		logger = log.NewNopLogger()
+	}
+
+	if err := block.Upload(ctx, logger, p.Bucket, blockDir, metadata.NoneFunc); err != nil {
+		return nil, errors.Wrapf(err, "upload block %s", meta.ULID)
+	}
+	return nil, nil
+}